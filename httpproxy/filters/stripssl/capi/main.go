@@ -0,0 +1,165 @@
+// +build cgo
+
+// Command capi exposes stripssl's RootCA through a C ABI so non-Go tooling
+// (Python test rigs, Rust proxies, Node scripts) can reuse the exact same
+// CA and leaf-caching logic without shelling out. Build it with:
+//
+//	go build -buildmode=c-shared -o libstripssl.so ./httpproxy/filters/stripssl/capi
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"sync"
+	"time"
+	"unsafe"
+
+	"../"
+)
+
+// leafValidFor is the lifetime given to every certificate minted by
+// stripssl_issue; it matches the ~13-month ceiling modern browsers enforce
+// on publicly trusted leaves.
+const leafValidFor = 397 * 24 * time.Hour
+
+type initConfig struct {
+	Name         string `json:"name"`
+	ValidFor     string `json:"valid_for"`
+	RSABits      int    `json:"rsa_bits"`
+	KeyAlgorithm string `json:"key_algorithm"`
+	CertDir      string `json:"cert_dir"`
+	CacheSize    int    `json:"cache_size"`
+	Portable     bool   `json:"portable"`
+}
+
+var (
+	mu sync.Mutex
+	ca *stripssl.RootCA
+)
+
+//export stripssl_init
+func stripssl_init(configJSON *C.char) C.int {
+	if configJSON == nil {
+		return -1
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var cfg initConfig
+	if err := json.Unmarshal([]byte(C.GoString(configJSON)), &cfg); err != nil {
+		return -1
+	}
+
+	vaildFor, err := time.ParseDuration(cfg.ValidFor)
+	if err != nil {
+		return -1
+	}
+
+	keyAlgorithm, err := stripssl.ParseKeyAlgorithm(cfg.KeyAlgorithm)
+	if err != nil {
+		return -1
+	}
+
+	rootCA, err := stripssl.NewRootCA(cfg.Name, vaildFor, cfg.RSABits, keyAlgorithm, nil, cfg.CertDir, cfg.CacheSize, cfg.Portable)
+	if err != nil {
+		return -1
+	}
+
+	ca = rootCA
+	return 0
+}
+
+//export stripssl_issue
+func stripssl_issue(domain *C.char, outPEM **C.char, outPEMLen *C.int) C.int {
+	if domain == nil || outPEM == nil || outPEMLen == nil {
+		return -1
+	}
+
+	host := C.GoString(domain)
+	if ip := net.ParseIP(host); ip != nil {
+		return stripssl_issue_ip(domain, outPEM, outPEMLen)
+	}
+
+	rootCA, ok := lockedCA()
+	if !ok {
+		return -1
+	}
+
+	commonName := stripssl.GetCommonName(host)
+	tlsCert, err := rootCA.Issue(commonName, leafValidFor, rootCA.RsaBits())
+	if err != nil {
+		return -1
+	}
+
+	return encodeTLSCert(tlsCert, outPEM, outPEMLen)
+}
+
+// stripssl_issue_ip is stripssl_issue's counterpart for literal IP
+// addresses, mirroring minica's generateCertificate/generateIPCertificate
+// split: an IP must never be run through GetCommonName's DNS wildcarding,
+// so it gets its own entry point that issues with the IP as the sole SAN.
+//
+//export stripssl_issue_ip
+func stripssl_issue_ip(ipStr *C.char, outPEM **C.char, outPEMLen *C.int) C.int {
+	if ipStr == nil || outPEM == nil || outPEMLen == nil {
+		return -1
+	}
+
+	ip := net.ParseIP(C.GoString(ipStr))
+	if ip == nil {
+		return -1
+	}
+
+	rootCA, ok := lockedCA()
+	if !ok {
+		return -1
+	}
+
+	tlsCert, err := rootCA.IssueWithSANs(ip.String(), nil, []net.IP{ip}, leafValidFor, rootCA.RsaBits())
+	if err != nil {
+		return -1
+	}
+
+	return encodeTLSCert(tlsCert, outPEM, outPEMLen)
+}
+
+func lockedCA() (*stripssl.RootCA, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	return ca, ca != nil
+}
+
+func encodeTLSCert(tlsCert *tls.Certificate, outPEM **C.char, outPEMLen *C.int) C.int {
+	if outPEM == nil || outPEMLen == nil {
+		return -1
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(tlsCert.PrivateKey)
+	if err != nil {
+		return -1
+	}
+
+	var combined []byte
+	combined = append(combined, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsCert.Certificate[0]})...)
+	combined = append(combined, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})...)
+
+	*outPEM = C.CString(string(combined))
+	*outPEMLen = C.int(len(combined))
+	return 0
+}
+
+//export stripssl_free
+func stripssl_free(ptr *C.char) {
+	C.free(unsafe.Pointer(ptr))
+}
+
+func main() {}
@@ -0,0 +1,145 @@
+package stripssl
+
+import (
+	"container/list"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/phuslu/glog"
+)
+
+// defaultCacheRenewWindow is how far ahead of a cached leaf's NotAfter the
+// background renewal goroutine re-issues it.
+const defaultCacheRenewWindow = 24 * time.Hour
+
+// cacheRenewInterval is how often the background goroutine scans the cache
+// for entries that need renewing.
+const cacheRenewInterval = 10 * time.Minute
+
+// defaultCacheSize is used by NewRootCA when the caller passes a
+// non-positive cacheSize.
+const defaultCacheSize = 1024
+
+type certCacheEntry struct {
+	commonName  string
+	tlsCert     *tls.Certificate
+	dnsNames    []string
+	ipAddresses []net.IP
+	vaildFor    time.Duration
+	rsaBits     int
+}
+
+// certCache is a bounded, in-memory LRU of issued *tls.Certificate values
+// keyed by common name, so a busy proxy doesn't pay for an os.Stat + PEM
+// decode + key parse on every handshake for a host it has already seen.
+type certCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newCertCache(size int) *certCache {
+	return &certCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *certCache) get(commonName string) (*certCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[commonName]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*certCacheEntry), true
+}
+
+func (c *certCache) add(entry *certCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[entry.commonName]; ok {
+		elem.Value = entry
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[entry.commonName] = c.ll.PushFront(entry)
+	if c.size > 0 {
+		for c.ll.Len() > c.size {
+			oldest := c.ll.Back()
+			if oldest == nil {
+				break
+			}
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*certCacheEntry).commonName)
+		}
+	}
+}
+
+func (c *certCache) remove(commonName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[commonName]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, commonName)
+	}
+}
+
+func (c *certCache) entries() []*certCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*certCacheEntry, 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*certCacheEntry))
+	}
+	return entries
+}
+
+// runCacheRenewal periodically re-issues cached leaves that are about to
+// expire. It never returns; NewRootCA and issueIntermediate start it in its
+// own goroutine for every RootCA they create.
+func (c *RootCA) runCacheRenewal() {
+	ticker := time.NewTicker(cacheRenewInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		renewWindow := c.renewWindow()
+		for _, entry := range c.cache.entries() {
+			if time.Until(entry.tlsCert.Leaf.NotAfter) > renewWindow {
+				continue
+			}
+
+			if err := c.Purge(entry.commonName); err != nil {
+				glog.Errorf("Purge(%#v) error: %v", entry.commonName, err)
+				continue
+			}
+
+			if _, err := c.IssueWithSANs(entry.commonName, entry.dnsNames, entry.ipAddresses, entry.vaildFor, entry.rsaBits); err != nil {
+				glog.Errorf("renew certificate for %#v error: %v", entry.commonName, err)
+			}
+		}
+	}
+}
+
+// SetCacheRenewWindow overrides defaultCacheRenewWindow.
+func (c *RootCA) SetCacheRenewWindow(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cacheRenewWindow = d
+}
+
+func (c *RootCA) renewWindow() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cacheRenewWindow
+}
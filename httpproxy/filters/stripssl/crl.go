@@ -0,0 +1,203 @@
+package stripssl
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultCRLValidity is how long a generated CRL is valid for before a
+// client should fetch a fresh one.
+const defaultCRLValidity = 24 * time.Hour
+
+type revocationRecord struct {
+	CommonName string    `json:"common_name"`
+	Serial     string    `json:"serial"`
+	RevokedAt  time.Time `json:"revoked_at"`
+	ReasonCode int       `json:"reason_code"`
+}
+
+// crlState is the persisted contents of revoked.json: the revocation
+// records plus the last CRL Number this RootCA issued. Persisting
+// CRLNumber alongside the records (rather than keeping it as in-memory
+// state on RootCA) keeps CRL numbering monotonically increasing across
+// process restarts, as RFC 5280 requires.
+type crlState struct {
+	Revocations map[string]revocationRecord `json:"revocations"`
+	CRLNumber   int64                       `json:"crl_number"`
+}
+
+func (c *RootCA) revocationsFile() string {
+	return c.certDir + "/revoked.json"
+}
+
+// loadCRLState returns the persisted revocation records, keyed by serial
+// number so revoking the same common name more than once (e.g. after a
+// Purge + re-issue) keeps every prior, still-unexpired serial on the CRL
+// instead of overwriting it, plus the last CRL Number issued.
+func (c *RootCA) loadCRLState() (*crlState, error) {
+	state := &crlState{Revocations: map[string]revocationRecord{}}
+
+	data, err := ioutil.ReadFile(c.revocationsFile())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Revocations == nil {
+		state.Revocations = map[string]revocationRecord{}
+	}
+	return state, nil
+}
+
+func (c *RootCA) saveCRLState(state *crlState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.revocationsFile(), data, 0644)
+}
+
+// Revoke marks commonName's currently issued leaf certificate as revoked
+// for reason (an RFC 5280 CRL reason code, e.g. 5 for cessationOfOperation) and
+// invalidates the cached CRL so the next CRL() call regenerates it.
+func (c *RootCA) Revoke(commonName string, reason int) error {
+	certFile := c.toFilename(commonName, ".crt")
+
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+
+	b, _ := pem.Decode(data)
+	if b == nil || b.Type != "CERTIFICATE" {
+		return fmt.Errorf("stripssl: no certificate found in %#v", certFile)
+	}
+
+	leaf, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, err := c.loadCRLState()
+	if err != nil {
+		return err
+	}
+
+	serial := leaf.SerialNumber.String()
+	state.Revocations[serial] = revocationRecord{
+		CommonName: commonName,
+		Serial:     serial,
+		RevokedAt:  time.Now(),
+		ReasonCode: reason,
+	}
+
+	if err := c.saveCRLState(state); err != nil {
+		return err
+	}
+
+	c.crlBytes = nil
+	return nil
+}
+
+// Purge deletes the cached leaf certificate for commonName so the next
+// Issue/IssueWithSANs call mints a fresh one.
+func (c *RootCA) Purge(commonName string) error {
+	c.cache.remove(commonName)
+
+	certFile := c.toFilename(commonName, ".crt")
+	if err := os.Remove(certFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SetCRLDistributionPoint sets the URL embedded as the CRLDistributionPoints
+// extension of every certificate issue mints from now on, typically the
+// address of the handler returned by CRLHandler.
+func (c *RootCA) SetCRLDistributionPoint(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crlURL = url
+}
+
+// CRL returns the DER-encoded CRL for this RootCA, (re)generating it if a
+// revocation has happened since the last call.
+func (c *RootCA) CRL() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.crlBytes != nil {
+		return c.crlBytes, nil
+	}
+
+	state, err := c.loadCRLState()
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make([]pkix.RevokedCertificate, 0, len(state.Revocations))
+	for _, r := range state.Revocations {
+		serial, ok := new(big.Int).SetString(r.Serial, 10)
+		if !ok {
+			return nil, fmt.Errorf("stripssl: invalid serial %#v in revocation list", r.Serial)
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: r.RevokedAt,
+		})
+	}
+
+	state.CRLNumber++
+	now := time.Now()
+
+	template := &x509.RevocationList{
+		RevokedCertificates: revoked,
+		Number:              big.NewInt(state.CRLNumber),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(defaultCRLValidity),
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, c.ca, c.priv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.saveCRLState(state); err != nil {
+		return nil, err
+	}
+
+	c.crlBytes = der
+	return c.crlBytes, nil
+}
+
+// CRLHandler returns an http.Handler serving the current DER-encoded CRL,
+// suitable for the proxy to register at the URL passed to
+// SetCRLDistributionPoint (e.g. "/crl/<name>.crl").
+func (c *RootCA) CRLHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		der, err := c.CRL()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(der)
+	})
+}
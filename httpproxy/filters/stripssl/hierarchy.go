@@ -0,0 +1,300 @@
+package stripssl
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hierarchyNodeConfig is one root or intermediate entry in a hierarchy JSON
+// config, see LoadHierarchy.
+type hierarchyNodeConfig struct {
+	Name         string `json:"name"`
+	ValidFor     string `json:"valid_for"`
+	KeyAlgorithm string `json:"key_algorithm"`
+	RSABits      int    `json:"rsa_bits,omitempty"`
+	// PathLen is the pathLenConstraint to embed in this node's
+	// BasicConstraints. A nil PathLen (the field omitted from the JSON)
+	// leaves the node unconstrained; only an explicit 0 forbids any
+	// further intermediates below it. PathLen is only applied to
+	// intermediates; the root node's PathLen is ignored.
+	PathLen       *int                  `json:"path_len,omitempty"`
+	HSM           *hierarchyHSMConfig   `json:"hsm,omitempty"`
+	Intermediates []hierarchyNodeConfig `json:"intermediates,omitempty"`
+}
+
+type hierarchyHSMConfig struct {
+	ModulePath string `json:"module_path"`
+	TokenLabel string `json:"token_label"`
+	Pin        string `json:"pin"`
+}
+
+// hierarchyRouteConfig maps an SNI pattern ("*.example.com" or an exact
+// hostname) to the name of the node in the hierarchy that should sign
+// leaves for it.
+type hierarchyRouteConfig struct {
+	Pattern string `json:"pattern"`
+	Signer  string `json:"signer"`
+}
+
+type hierarchyConfig struct {
+	CertDir   string                 `json:"cert_dir"`
+	CacheSize int                    `json:"cache_size,omitempty"`
+	Root      hierarchyNodeConfig    `json:"root"`
+	Routes    []hierarchyRouteConfig `json:"routes"`
+}
+
+type hierarchyRoute struct {
+	pattern string
+	signer  *RootCA
+}
+
+// Hierarchy is a root plus N intermediate *RootCA signers, loaded from a
+// JSON config with LoadHierarchy, with an SNI pattern routing table
+// selecting which signer issues leaves for a given host.
+type Hierarchy struct {
+	Root    *RootCA
+	signers map[string]*RootCA
+	routes  []hierarchyRoute
+}
+
+// LoadHierarchy reads a JSON document describing a root plus N
+// intermediates and materializes each node as a *RootCA-like signer, the
+// deepest ones chained back to the root so the leaves they issue carry a
+// full certificate chain. Use Hierarchy.Issue in place of a single
+// RootCA's Issue/IssueWithSANs.
+func LoadHierarchy(path string) (*Hierarchy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config hierarchyConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(config.CertDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(config.CertDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &Hierarchy{signers: make(map[string]*RootCA)}
+
+	root, err := buildHierarchyNode(&config.Root, nil, config.CertDir, config.CacheSize)
+	if err != nil {
+		return nil, err
+	}
+	h.Root = root
+	if err := h.index(&config.Root, root); err != nil {
+		return nil, err
+	}
+
+	for _, route := range config.Routes {
+		signer, ok := h.signers[route.Signer]
+		if !ok {
+			return nil, fmt.Errorf("stripssl: hierarchy route %#v references unknown signer %#v", route.Pattern, route.Signer)
+		}
+		h.routes = append(h.routes, hierarchyRoute{pattern: route.Pattern, signer: signer})
+	}
+
+	return h, nil
+}
+
+// index walks nodeConfig/node's already-materialized children in lockstep,
+// registering every node (including intermediates) by name so routes can
+// reference them.
+func (h *Hierarchy) index(nodeConfig *hierarchyNodeConfig, node *RootCA) error {
+	if _, dup := h.signers[nodeConfig.Name]; dup {
+		return fmt.Errorf("stripssl: duplicate hierarchy node name %#v", nodeConfig.Name)
+	}
+	h.signers[nodeConfig.Name] = node
+
+	for i := range nodeConfig.Intermediates {
+		child := node.children[nodeConfig.Intermediates[i].Name]
+		if err := h.index(&nodeConfig.Intermediates[i], child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildHierarchyNode(nodeConfig *hierarchyNodeConfig, parent *RootCA, certDir string, cacheSize int) (*RootCA, error) {
+	keyAlgorithm, err := parseKeyAlgorithm(nodeConfig.KeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	vaildFor, err := time.ParseDuration(nodeConfig.ValidFor)
+	if err != nil {
+		return nil, fmt.Errorf("stripssl: hierarchy node %#v: %v", nodeConfig.Name, err)
+	}
+
+	var keyStore KeyStore
+	if nodeConfig.HSM != nil {
+		keyStore = &PKCS11KeyStore{
+			ModulePath: nodeConfig.HSM.ModulePath,
+			TokenLabel: nodeConfig.HSM.TokenLabel,
+			Pin:        nodeConfig.HSM.Pin,
+		}
+	}
+
+	var node *RootCA
+	if parent == nil {
+		node, err = NewRootCA(nodeConfig.Name, vaildFor, nodeConfig.RSABits, keyAlgorithm, keyStore, certDir, cacheSize, false)
+	} else {
+		node, err = parent.issueIntermediate(nodeConfig.Name, vaildFor, keyAlgorithm, nodeConfig.RSABits, nodeConfig.PathLen, keyStore, certDir, cacheSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	node.children = make(map[string]*RootCA, len(nodeConfig.Intermediates))
+	for i := range nodeConfig.Intermediates {
+		child, err := buildHierarchyNode(&nodeConfig.Intermediates[i], node, certDir, cacheSize)
+		if err != nil {
+			return nil, err
+		}
+		node.children[nodeConfig.Intermediates[i].Name] = child
+	}
+
+	return node, nil
+}
+
+// issueIntermediate generates (or loads) a subordinate CA named name,
+// signed by c, and returns it as its own *RootCA so it can sign leaves (or
+// further intermediates) of its own. A nil pathLen leaves the node
+// unconstrained; an explicit value (including 0) is embedded as the
+// BasicConstraints pathLenConstraint.
+func (c *RootCA) issueIntermediate(name string, vaildFor time.Duration, keyAlgorithm KeyAlgorithm, rsaBits int, pathLen *int, keyStore KeyStore, certDir string, cacheSize int) (*RootCA, error) {
+	keyFile := filepath.Join(certDir, name+".key")
+	certFile := filepath.Join(certDir, name+".crt")
+
+	if keyStore == nil {
+		keyStore = &FileKeyStore{KeyFile: keyFile}
+	}
+
+	priv, err := keyStore.LoadOrGenerate(name, keyAlgorithm, rsaBits)
+	if err != nil {
+		return nil, err
+	}
+
+	var derBytes []byte
+	if _, err := os.Stat(certFile); os.IsNotExist(err) {
+		template := &x509.Certificate{
+			IsCA:               true,
+			SerialNumber:       big.NewInt(time.Now().UnixNano()),
+			SignatureAlgorithm: signatureAlgorithm(c.keyAlgorithm),
+			Subject: pkix.Name{
+				CommonName:   name,
+				Organization: []string{name},
+			},
+			NotBefore:             time.Now().Add(-time.Duration(30 * 24 * time.Hour)),
+			NotAfter:              time.Now().Add(vaildFor),
+			KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			BasicConstraintsValid: true,
+		}
+
+		if pathLen != nil {
+			template.MaxPathLen = *pathLen
+			template.MaxPathLenZero = *pathLen == 0
+		}
+
+		derBytes, err = x509.CreateCertificate(rand.Reader, template, c.ca, priv.Public(), c.priv)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0755); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := pem.Decode(data)
+		if b == nil || b.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("stripssl: no certificate found in %#v", certFile)
+		}
+		derBytes = b.Bytes
+	}
+
+	ca, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheSize <= 0 {
+		cacheSize = defaultCacheSize
+	}
+
+	node := &RootCA{
+		name:             name,
+		keyFile:          keyFile,
+		certFile:         certFile,
+		rsaBits:          rsaBits,
+		keyAlgorithm:     keyAlgorithm,
+		certDir:          certDir,
+		mu:               new(sync.Mutex),
+		ca:               ca,
+		priv:             priv,
+		derBytes:         derBytes,
+		cache:            newCertCache(cacheSize),
+		cacheRenewWindow: defaultCacheRenewWindow,
+		certChainDER:     append([][]byte{derBytes}, c.certChainDER...),
+	}
+
+	go node.runCacheRenewal()
+
+	return node, nil
+}
+
+// SignerFor returns the signer of the first route, in config order, whose
+// pattern matches sni, falling back to the root if no route matches.
+func (h *Hierarchy) SignerFor(sni string) *RootCA {
+	for _, route := range h.routes {
+		if hierarchyPatternMatches(route.pattern, sni) {
+			return route.signer
+		}
+	}
+	return h.Root
+}
+
+func hierarchyPatternMatches(pattern, sni string) bool {
+	if pattern == sni {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(sni, pattern[1:])
+	}
+	return false
+}
+
+// Issue mints (or returns the cached) leaf certificate for domain using
+// whichever signer SignerFor routes it to. domain may be a literal IP
+// address for IP-literal CONNECTs, in which case it is used as-is rather
+// than run through GetCommonName.
+func (h *Hierarchy) Issue(domain string, vaildFor time.Duration, rsaBits int) (*tls.Certificate, error) {
+	signer := h.SignerFor(domain)
+
+	if ip := net.ParseIP(domain); ip != nil {
+		return signer.IssueWithSANs(domain, nil, []net.IP{ip}, vaildFor, rsaBits)
+	}
+
+	return signer.Issue(GetCommonName(domain), vaildFor, rsaBits)
+}
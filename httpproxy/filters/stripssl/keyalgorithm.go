@@ -0,0 +1,108 @@
+package stripssl
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// KeyAlgorithm selects the key type used for a RootCA's root and leaf
+// certificates.
+type KeyAlgorithm int
+
+const (
+	RSA KeyAlgorithm = iota
+	ECDSAP256
+	ECDSAP384
+	Ed25519
+)
+
+// ParseKeyAlgorithm parses the names produced by KeyAlgorithm.String, as
+// used in hierarchy JSON configs and by external callers (e.g. capi). An
+// empty string means RSA.
+func ParseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	return parseKeyAlgorithm(s)
+}
+
+func parseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	switch s {
+	case "", "RSA":
+		return RSA, nil
+	case "ECDSA-P256":
+		return ECDSAP256, nil
+	case "ECDSA-P384":
+		return ECDSAP384, nil
+	case "Ed25519":
+		return Ed25519, nil
+	default:
+		return RSA, fmt.Errorf("stripssl: unknown key algorithm %#v", s)
+	}
+}
+
+func (a KeyAlgorithm) String() string {
+	switch a {
+	case RSA:
+		return "RSA"
+	case ECDSAP256:
+		return "ECDSA-P256"
+	case ECDSAP384:
+		return "ECDSA-P384"
+	case Ed25519:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("KeyAlgorithm(%d)", int(a))
+	}
+}
+
+// generateKey returns a freshly generated private key for alg. rsaBits is
+// only consulted when alg is RSA.
+func generateKey(alg KeyAlgorithm, rsaBits int) (crypto.Signer, error) {
+	switch alg {
+	case RSA:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("stripssl: unknown key algorithm %v", alg)
+	}
+}
+
+// signatureAlgorithm returns the x509.SignatureAlgorithm that must be used
+// to sign a certificate whose key is of the given algorithm.
+func signatureAlgorithm(alg KeyAlgorithm) x509.SignatureAlgorithm {
+	switch alg {
+	case ECDSAP256:
+		return x509.ECDSAWithSHA256
+	case ECDSAP384:
+		return x509.ECDSAWithSHA384
+	case Ed25519:
+		return x509.PureEd25519
+	default:
+		return x509.SHA256WithRSA
+	}
+}
+
+// parsePrivateKey decodes a PEM-block's bytes as a PKCS#8 private key,
+// falling back to PKCS#1 for keys written by older versions that only
+// ever stored RSA keys that way.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("stripssl: unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+
+	return x509.ParsePKCS1PrivateKey(der)
+}
@@ -0,0 +1,70 @@
+package stripssl
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+)
+
+// KeyStore abstracts where a RootCA's root private key lives. The default
+// FileKeyStore keeps it on disk next to the root certificate; PKCS11KeyStore
+// (keystore_pkcs11.go, cgo builds only) keeps it resident on an HSM/softhsm2 token so
+// the key material never touches the filesystem.
+type KeyStore interface {
+	// LoadOrGenerate returns the signer for name, generating one with the
+	// given algorithm (and, for RSA, bit size) and persisting it if none
+	// exists yet.
+	LoadOrGenerate(name string, alg KeyAlgorithm, rsaBits int) (crypto.Signer, error)
+}
+
+// FileKeyStore stores the root private key on disk as a PEM file, as RootCA
+// has always done. New keys are written as PKCS#8; PKCS#1 keys written by
+// older versions are still read.
+type FileKeyStore struct {
+	KeyFile string
+}
+
+func (s *FileKeyStore) LoadOrGenerate(name string, alg KeyAlgorithm, rsaBits int) (crypto.Signer, error) {
+	if _, err := os.Stat(s.KeyFile); os.IsNotExist(err) {
+		priv, err := generateKey(alg, rsaBits)
+		if err != nil {
+			return nil, err
+		}
+
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+
+		keypem := &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}
+		if err = ioutil.WriteFile(s.KeyFile, pem.EncodeToMemory(keypem), 0755); err != nil {
+			return nil, err
+		}
+
+		return priv, nil
+	}
+
+	data, err := ioutil.ReadFile(s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var priv crypto.Signer
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			break
+		}
+		if b.Type == "PRIVATE KEY" {
+			priv, err = parsePrivateKey(b.Bytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return priv, nil
+}
@@ -0,0 +1,59 @@
+// +build cgo
+
+package stripssl
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// PKCS11KeyStore keeps the root private key resident on a PKCS#11 token
+// (an HSM, or softhsm2 for local testing) instead of on disk. The root
+// private key never leaves the token; x509.CreateCertificate is handed the
+// crypto.Signer this returns and the token performs the actual signing.
+type PKCS11KeyStore struct {
+	// ModulePath is the PKCS#11 module (.so/.dll) to load. If empty,
+	// defaultModulePath (platform-specific, see keystore_pkcs11_*.go) is
+	// used.
+	ModulePath string
+	TokenLabel string
+	Pin        string
+}
+
+func (s *PKCS11KeyStore) modulePath() string {
+	if s.ModulePath != "" {
+		return s.ModulePath
+	}
+	return defaultModulePath
+}
+
+func (s *PKCS11KeyStore) LoadOrGenerate(name string, alg KeyAlgorithm, rsaBits int) (crypto.Signer, error) {
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       s.modulePath(),
+		TokenLabel: s.TokenLabel,
+		Pin:        s.Pin,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := []byte(name)
+
+	if signer, err := ctx.FindKeyPair(id, nil); err == nil && signer != nil {
+		return signer, nil
+	}
+
+	switch alg {
+	case RSA:
+		return ctx.GenerateRSAKeyPairWithLabel(id, []byte(name), rsaBits)
+	case ECDSAP256:
+		return ctx.GenerateECDSAKeyPairWithLabel(id, []byte(name), elliptic.P256())
+	case ECDSAP384:
+		return ctx.GenerateECDSAKeyPairWithLabel(id, []byte(name), elliptic.P384())
+	default:
+		return nil, fmt.Errorf("stripssl: key algorithm %v is not supported by PKCS11KeyStore", alg)
+	}
+}
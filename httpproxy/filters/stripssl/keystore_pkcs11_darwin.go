@@ -0,0 +1,6 @@
+// +build cgo
+
+package stripssl
+
+// defaultModulePath is where Homebrew installs softhsm2's PKCS#11 module.
+const defaultModulePath = "/usr/local/lib/softhsm/libsofthsm2.so"
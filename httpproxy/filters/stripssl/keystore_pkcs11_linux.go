@@ -0,0 +1,7 @@
+// +build cgo
+
+package stripssl
+
+// defaultModulePath is where softhsm2 installs its PKCS#11 module on the
+// major Linux distributions that ship it.
+const defaultModulePath = "/usr/lib/softhsm/libsofthsm2.so"
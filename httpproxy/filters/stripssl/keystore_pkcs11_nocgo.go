@@ -0,0 +1,23 @@
+// +build !cgo
+
+package stripssl
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// PKCS11KeyStore is the non-cgo stand-in used when the binary is built
+// with CGO_ENABLED=0 (e.g. static/cross-compiled proxy builds). It keeps
+// the type available so callers (and hierarchy.go's HSM config branch) can
+// reference it unconditionally, but LoadOrGenerate always fails since the
+// real PKCS#11 backend (keystore_pkcs11.go) requires cgo.
+type PKCS11KeyStore struct {
+	ModulePath string
+	TokenLabel string
+	Pin        string
+}
+
+func (s *PKCS11KeyStore) LoadOrGenerate(name string, alg KeyAlgorithm, rsaBits int) (crypto.Signer, error) {
+	return nil, fmt.Errorf("stripssl: PKCS11KeyStore requires a cgo build")
+}
@@ -0,0 +1,7 @@
+// +build cgo
+
+package stripssl
+
+// defaultModulePath is where the softhsm2 Windows installer places its
+// PKCS#11 module.
+const defaultModulePath = `C:\SoftHSM2\lib\softhsm2.dll`
@@ -1,14 +1,15 @@
 package stripssl
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -22,19 +23,43 @@ import (
 )
 
 type RootCA struct {
-	name     string
-	keyFile  string
-	certFile string
-	rsaBits  int
-	certDir  string
-	mu       *sync.Mutex
+	name         string
+	keyFile      string
+	certFile     string
+	rsaBits      int
+	keyAlgorithm KeyAlgorithm
+	certDir      string
+	mu           *sync.Mutex
 
 	ca       *x509.Certificate
-	priv     *rsa.PrivateKey
+	priv     crypto.Signer
 	derBytes []byte
+
+	crlURL   string
+	crlBytes []byte
+
+	cache            *certCache
+	cacheRenewWindow time.Duration
+
+	// certChainDER holds this RootCA's own certificate followed by any of
+	// its ancestor intermediates, root-most last, excluding the root
+	// itself. It is prepended to every leaf this RootCA issues so clients
+	// that don't carry the intermediate in their trust store can still
+	// build a full chain. Populated by LoadHierarchy; nil for a plain
+	// NewRootCA.
+	certChainDER [][]byte
+
+	// children holds this node's direct intermediates, keyed by name, as
+	// materialized by LoadHierarchy.
+	children map[string]*RootCA
 }
 
-func NewRootCA(name string, vaildFor time.Duration, rsaBits int, certDir string, portable bool) (*RootCA, error) {
+// NewRootCA loads or creates the named root CA. keyStore controls where the
+// root private key lives; pass nil to keep it on disk next to certFile
+// (the historical behavior), or a *PKCS11KeyStore to keep it HSM-resident.
+// cacheSize bounds the in-memory LRU of issued leaf certificates; values
+// <= 0 fall back to defaultCacheSize.
+func NewRootCA(name string, vaildFor time.Duration, rsaBits int, keyAlgorithm KeyAlgorithm, keyStore KeyStore, certDir string, cacheSize int, portable bool) (*RootCA, error) {
 	keyFile := name + ".key"
 	certFile := name + ".crt"
 
@@ -45,20 +70,38 @@ func NewRootCA(name string, vaildFor time.Duration, rsaBits int, certDir string,
 		certDir = filepath.Join(rootdir, certDir)
 	}
 
+	if keyStore == nil {
+		keyStore = &FileKeyStore{KeyFile: keyFile}
+	}
+
+	if cacheSize <= 0 {
+		cacheSize = defaultCacheSize
+	}
+
 	rootCA := &RootCA{
-		name:     name,
-		keyFile:  keyFile,
-		certFile: certFile,
-		rsaBits:  rsaBits,
-		certDir:  certDir,
-		mu:       new(sync.Mutex),
+		name:             name,
+		keyFile:          keyFile,
+		certFile:         certFile,
+		rsaBits:          rsaBits,
+		keyAlgorithm:     keyAlgorithm,
+		certDir:          certDir,
+		mu:               new(sync.Mutex),
+		cache:            newCertCache(cacheSize),
+		cacheRenewWindow: defaultCacheRenewWindow,
 	}
 
+	priv, err := keyStore.LoadOrGenerate(name, keyAlgorithm, rsaBits)
+	if err != nil {
+		return nil, err
+	}
+	rootCA.priv = priv
+
 	if _, err := os.Stat(certFile); os.IsNotExist(err) {
 		glog.Infof("Generating RootCA for %s", certFile)
 		template := x509.Certificate{
-			IsCA:         true,
-			SerialNumber: big.NewInt(1),
+			IsCA:               true,
+			SerialNumber:       big.NewInt(1),
+			SignatureAlgorithm: signatureAlgorithm(keyAlgorithm),
 			Subject: pkix.Name{
 				CommonName:   name,
 				Organization: []string{name},
@@ -71,12 +114,7 @@ func NewRootCA(name string, vaildFor time.Duration, rsaBits int, certDir string,
 			BasicConstraintsValid: true,
 		}
 
-		priv, err := rsa.GenerateKey(rand.Reader, rsaBits)
-		if err != nil {
-			return nil, err
-		}
-
-		derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+		derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
 		if err != nil {
 			return nil, err
 		}
@@ -87,52 +125,20 @@ func NewRootCA(name string, vaildFor time.Duration, rsaBits int, certDir string,
 		}
 
 		rootCA.ca = ca
-		rootCA.priv = priv
 		rootCA.derBytes = derBytes
 
-		keypem := &pem.Block{Type: "PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rootCA.priv)}
-		if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(keypem), 0755); err != nil {
-			return nil, err
-		}
-
 		certpem := &pem.Block{Type: "CERTIFICATE", Bytes: rootCA.derBytes}
 		if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(certpem), 0755); err != nil {
 			return nil, err
 		}
 	} else {
-		data, err := ioutil.ReadFile(keyFile)
-		if err != nil {
-			return nil, err
-		}
-
-		var b *pem.Block
-		for {
-			b, data = pem.Decode(data)
-			if b == nil {
-				break
-			}
-			if b.Type == "CERTIFICATE" {
-				rootCA.derBytes = b.Bytes
-				ca, err := x509.ParseCertificate(rootCA.derBytes)
-				if err != nil {
-					return nil, err
-				}
-				rootCA.ca = ca
-			} else if b.Type == "PRIVATE KEY" {
-				priv, err := x509.ParsePKCS1PrivateKey(b.Bytes)
-				if err != nil {
-					return nil, err
-				}
-				rootCA.priv = priv
-			}
-		}
-
-		data, err = ioutil.ReadFile(certFile)
+		data, err := ioutil.ReadFile(certFile)
 		if err != nil {
 			return nil, err
 		}
 
 		for {
+			var b *pem.Block
 			b, data = pem.Decode(data)
 			if b == nil {
 				break
@@ -144,12 +150,6 @@ func NewRootCA(name string, vaildFor time.Duration, rsaBits int, certDir string,
 					return nil, err
 				}
 				rootCA.ca = ca
-			} else if b.Type == "PRIVATE KEY" {
-				priv, err := x509.ParsePKCS1PrivateKey(b.Bytes)
-				if err != nil {
-					return nil, err
-				}
-				rootCA.priv = priv
 			}
 		}
 	}
@@ -183,10 +183,12 @@ func NewRootCA(name string, vaildFor time.Duration, rsaBits int, certDir string,
 		}
 	}
 
+	go rootCA.runCacheRenewal()
+
 	return rootCA, nil
 }
 
-func (c *RootCA) issue(commonName string, vaildFor time.Duration, rsaBits int) error {
+func (c *RootCA) issue(commonName string, dnsNames []string, ipAddresses []net.IP, vaildFor time.Duration, rsaBits int) error {
 	certFile := c.toFilename(commonName, ".crt")
 
 	csrTemplate := &x509.CertificateRequest{
@@ -197,10 +199,12 @@ func (c *RootCA) issue(commonName string, vaildFor time.Duration, rsaBits int) e
 			OrganizationalUnit: []string{c.name},
 			CommonName:         commonName,
 		},
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		DNSNames:           dnsNames,
+		IPAddresses:        ipAddresses,
+		SignatureAlgorithm: signatureAlgorithm(c.keyAlgorithm),
 	}
 
-	priv, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	priv, err := generateKey(c.keyAlgorithm, rsaBits)
 	if err != nil {
 		return err
 	}
@@ -219,8 +223,10 @@ func (c *RootCA) issue(commonName string, vaildFor time.Duration, rsaBits int) e
 		Subject:            csr.Subject,
 		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
 		PublicKey:          csr.PublicKey,
+		DNSNames:           csr.DNSNames,
+		IPAddresses:        csr.IPAddresses,
 		SerialNumber:       big.NewInt(time.Now().UnixNano()),
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		SignatureAlgorithm: signatureAlgorithm(c.keyAlgorithm),
 		NotBefore:          time.Now().Add(-time.Duration(30 * 24 * time.Hour)),
 		NotAfter:           time.Now().Add(vaildFor),
 		KeyUsage:           x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
@@ -230,18 +236,30 @@ func (c *RootCA) issue(commonName string, vaildFor time.Duration, rsaBits int) e
 		},
 	}
 
+	if c.crlURL != "" {
+		certTemplate.CRLDistributionPoints = []string{c.crlURL}
+	}
+
 	certBytes, err := x509.CreateCertificate(rand.Reader, certTemplate, c.ca, csr.PublicKey, c.priv)
 	if err != nil {
 		return err
 	}
 
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
 	outFile, err := os.Create(certFile)
 	defer outFile.Close()
 	if err != nil {
 		return err
 	}
 	pem.Encode(outFile, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
-	pem.Encode(outFile, &pem.Block{Type: "PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	for _, der := range c.certChainDER {
+		pem.Encode(outFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	pem.Encode(outFile, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
 
 	return nil
 }
@@ -268,14 +286,44 @@ func (c *RootCA) RsaBits() int {
 	return c.rsaBits
 }
 
+func (c *RootCA) KeyAlgorithm() KeyAlgorithm {
+	return c.keyAlgorithm
+}
+
 func (c *RootCA) toFilename(commonName, suffix string) string {
 	if strings.HasPrefix(commonName, "*.") {
 		commonName = commonName[1:]
 	}
+	if ip := net.ParseIP(commonName); ip != nil {
+		// IPv6 literals contain ':', which is not a safe filename
+		// character on several platforms.
+		commonName = strings.Replace(commonName, ":", "_", -1)
+	}
 	return c.certDir + "/" + commonName + suffix
 }
 
+// Issue returns a leaf certificate for commonName, minting one with
+// commonName as its sole SAN if it is not already cached: an IP SAN if
+// commonName is a literal IP address, a DNS SAN otherwise.
 func (c *RootCA) Issue(commonName string, vaildFor time.Duration, rsaBits int) (*tls.Certificate, error) {
+	var dnsNames []string
+	var ipAddresses []net.IP
+	if ip := net.ParseIP(commonName); ip != nil {
+		ipAddresses = append(ipAddresses, ip)
+	} else {
+		dnsNames = append(dnsNames, commonName)
+	}
+	return c.IssueWithSANs(commonName, dnsNames, ipAddresses, vaildFor, rsaBits)
+}
+
+// IssueWithSANs is like Issue but lets the caller control the exact
+// DNSNames/IPAddresses SAN lists, which is required for literal-IP
+// CONNECTs where commonName itself is an address rather than a hostname.
+func (c *RootCA) IssueWithSANs(commonName string, dnsNames []string, ipAddresses []net.IP, vaildFor time.Duration, rsaBits int) (*tls.Certificate, error) {
+	if entry, ok := c.cache.get(commonName); ok {
+		return entry.tlsCert, nil
+	}
+
 	certFile := c.toFilename(commonName, ".crt")
 
 	if _, err := os.Stat(certFile); os.IsNotExist(err) {
@@ -283,7 +331,7 @@ func (c *RootCA) Issue(commonName string, vaildFor time.Duration, rsaBits int) (
 		c.mu.Lock()
 		defer c.mu.Unlock()
 		if _, err := os.Stat(certFile); os.IsNotExist(err) {
-			if err = c.issue(commonName, vaildFor, rsaBits); err != nil {
+			if err = c.issue(commonName, dnsNames, ipAddresses, vaildFor, rsaBits); err != nil {
 				return nil, err
 			}
 		}
@@ -293,5 +341,22 @@ func (c *RootCA) Issue(commonName string, vaildFor time.Duration, rsaBits int) (
 	if err != nil {
 		return nil, err
 	}
+
+	if tlsCert.Leaf == nil {
+		tlsCert.Leaf, err = x509.ParseCertificate(tlsCert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.cache.add(&certCacheEntry{
+		commonName:  commonName,
+		tlsCert:     &tlsCert,
+		dnsNames:    dnsNames,
+		ipAddresses: ipAddresses,
+		vaildFor:    vaildFor,
+		rsaBits:     rsaBits,
+	})
+
 	return &tlsCert, nil
 }